@@ -17,6 +17,7 @@ package authenticate
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	oidc "github.com/coreos/go-oidc"
 	"golang.org/x/net/context"
@@ -43,12 +44,25 @@ type AuthSource int
 const (
 	AuthSourceClientCertificate AuthSource = iota
 	AuthSourceIDToken
+	AuthSourceJWTSVID
 )
 
 // Caller carries the identity and authentication source of a caller.
 type Caller struct {
 	AuthSource AuthSource
 	Identities []string
+
+	// AuthenticatorType records which Authenticator implementation produced
+	// this Caller, e.g. ClientCertAuthenticatorType or IDTokenAuthenticatorType.
+	// It lets authorization policies distinguish authenticators that share
+	// an AuthSource.
+	AuthenticatorType string
+
+	// Claims holds the raw claims of the token the caller presented, if any.
+	// It is nil for ClientCertAuthenticator callers. Authorization policies
+	// can use it to enforce checks like "IDToken callers must present
+	// cluster-id metadata".
+	Claims map[string]interface{}
 }
 
 // ClientCertAuthenticator extracts identities from client certificate.
@@ -84,8 +98,9 @@ func (cca *ClientCertAuthenticator) Authenticate(ctx context.Context) (*Caller,
 	}
 
 	return &Caller{
-		AuthSource: AuthSourceClientCertificate,
-		Identities: ids,
+		AuthSource:        AuthSourceClientCertificate,
+		Identities:        ids,
+		AuthenticatorType: ClientCertAuthenticatorType,
 	}, nil
 }
 
@@ -93,17 +108,56 @@ func (cca *ClientCertAuthenticator) Authenticate(ctx context.Context) (*Caller,
 // transmitted using the "Bearer" authentication scheme.
 type IDTokenAuthenticator struct {
 	verifier *oidc.IDTokenVerifier
+
+	// nonceSource, replayCache and maxTokenAge are optional replay
+	// protections, off by default. See WithNonceSource, WithReplayProtection
+	// and WithMaxTokenAge.
+	nonceSource NonceSource
+	replayCache *jtiReplayCache
+	maxTokenAge time.Duration
 }
 
-// NewIDTokenAuthenticator creates a new IDTokenAuthenticator.
-func NewIDTokenAuthenticator(aud string) (*IDTokenAuthenticator, error) {
+// IDTokenOption configures optional behavior on an IDTokenAuthenticator.
+type IDTokenOption func(*IDTokenAuthenticator)
+
+// WithNonceSource rejects ID tokens with a missing, unknown, or already
+// consumed `nonce` claim, as determined by ns.
+func WithNonceSource(ns NonceSource) IDTokenOption {
+	return func(a *IDTokenAuthenticator) {
+		a.nonceSource = ns
+	}
+}
+
+// WithReplayProtection rejects ID tokens whose `jti` claim has already been
+// seen, using an in-memory LRU bounded by capacity (0 selects a default).
+func WithReplayProtection(capacity int) IDTokenOption {
+	return func(a *IDTokenAuthenticator) {
+		a.replayCache = newJTIReplayCache(capacity)
+	}
+}
+
+// WithMaxTokenAge rejects ID tokens whose `iat` claim is older than maxAge.
+func WithMaxTokenAge(maxAge time.Duration) IDTokenOption {
+	return func(a *IDTokenAuthenticator) {
+		a.maxTokenAge = maxAge
+	}
+}
+
+// NewIDTokenAuthenticator creates a new IDTokenAuthenticator. Replay
+// protection is off by default; opt in with WithNonceSource,
+// WithReplayProtection and/or WithMaxTokenAge.
+func NewIDTokenAuthenticator(aud string, opts ...IDTokenOption) (*IDTokenAuthenticator, error) {
 	provider, err := oidc.NewProvider(context.Background(), idTokenIssuer)
 	if err != nil {
 		return nil, err
 	}
 
 	verifier := provider.Verifier(&oidc.Config{ClientID: aud})
-	return &IDTokenAuthenticator{verifier}, nil
+	a := &IDTokenAuthenticator{verifier: verifier}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a, nil
 }
 
 func (a *IDTokenAuthenticator) AuthenticatorType() string {
@@ -130,12 +184,60 @@ func (a *IDTokenAuthenticator) Authenticate(ctx context.Context) (*Caller, error
 		return nil, fmt.Errorf("failed to extract email field from ID token: %v", err)
 	}
 
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to extract claims from ID token: %v", err)
+	}
+
+	if err := a.checkReplay(idToken, claims); err != nil {
+		return nil, err
+	}
+
 	return &Caller{
-		AuthSource: AuthSourceIDToken,
-		Identities: []string{sa.Email},
+		AuthSource:        AuthSourceIDToken,
+		Identities:        []string{sa.Email},
+		AuthenticatorType: IDTokenAuthenticatorType,
+		Claims:            claims,
 	}, nil
 }
 
+// checkReplay applies whichever of the optional replay protections are
+// configured on a; it is a no-op when none are configured.
+func (a *IDTokenAuthenticator) checkReplay(idToken *oidc.IDToken, claims map[string]interface{}) error {
+	if a.nonceSource != nil {
+		nonce, _ := claims["nonce"].(string)
+		if nonce == "" {
+			return fmt.Errorf("ID token has no nonce claim")
+		}
+		if err := a.nonceSource.VerifyNonce(nonce); err != nil {
+			return fmt.Errorf("nonce verification failed: %v", err)
+		}
+	}
+
+	if a.maxTokenAge > 0 {
+		iat, ok := claims["iat"].(float64)
+		if !ok {
+			return fmt.Errorf("ID token has no iat claim")
+		}
+		age := time.Since(time.Unix(int64(iat), 0))
+		if age > a.maxTokenAge {
+			return fmt.Errorf("ID token age %s exceeds maximum allowed age %s", age, a.maxTokenAge)
+		}
+	}
+
+	if a.replayCache != nil {
+		jti, ok := claims["jti"].(string)
+		if !ok || jti == "" {
+			return fmt.Errorf("ID token has no jti claim, cannot enforce replay protection")
+		}
+		if err := a.replayCache.checkAndRecord(jti, idToken.Expiry); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func extractBearerToken(ctx context.Context) (string, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
@@ -0,0 +1,139 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+
+	"istio.io/istio/pkg/log"
+)
+
+const AuthenticatorChainType = "AuthenticatorChain"
+
+// Authenticator is the common interface implemented by all authenticators in
+// this package so that AuthenticatorChain can compose them.
+type Authenticator interface {
+	Authenticate(ctx context.Context) (*Caller, error)
+	AuthenticatorType() string
+}
+
+// AuditEvent records the outcome of a single authenticator's attempt within
+// an AuthenticatorChain invocation, for consumption by an AuditSink.
+type AuditEvent struct {
+	AuthenticatorType string   `json:"authenticatorType"`
+	Success           bool     `json:"success"`
+	Error             string   `json:"error,omitempty"`
+	Identities        []string `json:"identities,omitempty"`
+}
+
+// AuditSink receives one AuditEvent per authenticator attempted during a
+// single AuthenticatorChain.Authenticate call, in order.
+type AuditSink interface {
+	Audit(events []AuditEvent)
+}
+
+// LogAuditSink is the default AuditSink: one JSON line per attempt at debug
+// level.
+type LogAuditSink struct{}
+
+func (LogAuditSink) Audit(events []AuditEvent) {
+	for _, event := range events {
+		b, err := json.Marshal(event)
+		if err != nil {
+			log.Errorf("failed to marshal audit event: %v", err)
+			continue
+		}
+		log.Debugf("%s", b)
+	}
+}
+
+// chainError aggregates the errors returned by every authenticator attempted
+// by an AuthenticatorChain, so a single failure response still shows why
+// each authenticator rejected the caller.
+type chainError struct {
+	attempts []AuditEvent
+}
+
+func (e *chainError) Error() string {
+	reasons := make([]string, 0, len(e.attempts))
+	for _, a := range e.attempts {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", a.AuthenticatorType, a.Error))
+	}
+	return fmt.Sprintf("authentication failed for all %d authenticator(s): %s", len(e.attempts), strings.Join(reasons, "; "))
+}
+
+// AuthenticatorChain runs a configurable ordered list of authenticators
+// against an incoming request, returning the first successful Caller and
+// short-circuiting the rest.
+type AuthenticatorChain struct {
+	authenticators []Authenticator
+	sink           AuditSink
+}
+
+// NewAuthenticatorChain creates an AuthenticatorChain that tries
+// authenticators in order, auditing every attempt to a LogAuditSink. Use
+// WithAuditSink to override the sink.
+func NewAuthenticatorChain(authenticators ...Authenticator) *AuthenticatorChain {
+	return &AuthenticatorChain{
+		authenticators: authenticators,
+		sink:           LogAuditSink{},
+	}
+}
+
+// WithAuditSink replaces the chain's AuditSink and returns the chain for
+// chaining calls.
+func (c *AuthenticatorChain) WithAuditSink(sink AuditSink) *AuthenticatorChain {
+	c.sink = sink
+	return c
+}
+
+func (c *AuthenticatorChain) AuthenticatorType() string {
+	return AuthenticatorChainType
+}
+
+// Authenticate tries each authenticator in order and returns the Caller
+// produced by the first one that succeeds. If every authenticator fails, it
+// returns a single error aggregating all of their failures. Every attempt,
+// successful or not, is reported to the chain's AuditSink before returning.
+func (c *AuthenticatorChain) Authenticate(ctx context.Context) (*Caller, error) {
+	events := make([]AuditEvent, 0, len(c.authenticators))
+
+	for _, a := range c.authenticators {
+		caller, err := a.Authenticate(ctx)
+		if err != nil {
+			events = append(events, AuditEvent{
+				AuthenticatorType: a.AuthenticatorType(),
+				Success:           false,
+				Error:             err.Error(),
+			})
+			continue
+		}
+
+		events = append(events, AuditEvent{
+			AuthenticatorType: a.AuthenticatorType(),
+			Success:           true,
+			Identities:        caller.Identities,
+		})
+		c.sink.Audit(events)
+		return caller, nil
+	}
+
+	c.sink.Audit(events)
+	return nil, &chainError{attempts: events}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// fakeAuthenticator is a test double that always returns the configured
+// caller or error.
+type fakeAuthenticator struct {
+	authType string
+	caller   *Caller
+	err      error
+}
+
+func (f *fakeAuthenticator) AuthenticatorType() string { return f.authType }
+
+func (f *fakeAuthenticator) Authenticate(ctx context.Context) (*Caller, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.caller, nil
+}
+
+func TestAuthenticatorChain_Authenticate(t *testing.T) {
+	succeed := &fakeAuthenticator{authType: "succeed", caller: &Caller{Identities: []string{"id-succeed"}}}
+	fail := &fakeAuthenticator{authType: "fail", err: fmt.Errorf("nope")}
+	succeedAgain := &fakeAuthenticator{authType: "succeed-again", caller: &Caller{Identities: []string{"id-succeed-again"}}}
+
+	tests := []struct {
+		name           string
+		authenticators []Authenticator
+		wantIdentity   string
+		wantErr        bool
+	}{
+		{
+			name:           "first succeeds, short-circuits the rest",
+			authenticators: []Authenticator{succeed, succeedAgain},
+			wantIdentity:   "id-succeed",
+		},
+		{
+			name:           "first fails, second succeeds",
+			authenticators: []Authenticator{fail, succeed},
+			wantIdentity:   "id-succeed",
+		},
+		{
+			name:           "all fail",
+			authenticators: []Authenticator{fail, fail},
+			wantErr:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewAuthenticatorChain(tt.authenticators...)
+			caller, err := c.Authenticate(context.Background())
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Authenticate() = %v, nil; want error", caller)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Authenticate() returned unexpected error: %v", err)
+			}
+			if len(caller.Identities) != 1 || caller.Identities[0] != tt.wantIdentity {
+				t.Fatalf("Authenticate() identities = %v, want [%q]", caller.Identities, tt.wantIdentity)
+			}
+		})
+	}
+}
+
+func TestAuthenticatorChain_ErrorAggregatesEveryAttempt(t *testing.T) {
+	fail1 := &fakeAuthenticator{authType: "first", err: fmt.Errorf("reason one")}
+	fail2 := &fakeAuthenticator{authType: "second", err: fmt.Errorf("reason two")}
+
+	c := NewAuthenticatorChain(fail1, fail2)
+	_, err := c.Authenticate(context.Background())
+	if err == nil {
+		t.Fatalf("Authenticate() = nil error; want aggregated failure")
+	}
+
+	for _, want := range []string{"first", "reason one", "second", "reason two"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Fatalf("Authenticate() error %q does not mention %q", err.Error(), want)
+		}
+	}
+}
+
+// fakeAuditSink records every Audit call it receives.
+type fakeAuditSink struct {
+	calls [][]AuditEvent
+}
+
+func (f *fakeAuditSink) Audit(events []AuditEvent) {
+	f.calls = append(f.calls, events)
+}
+
+func TestAuthenticatorChain_WithAuditSinkReceivesEveryAttempt(t *testing.T) {
+	fail := &fakeAuthenticator{authType: "fail", err: fmt.Errorf("nope")}
+	succeed := &fakeAuthenticator{authType: "succeed", caller: &Caller{Identities: []string{"id-succeed"}}}
+
+	sink := &fakeAuditSink{}
+	c := NewAuthenticatorChain(fail, succeed).WithAuditSink(sink)
+
+	if _, err := c.Authenticate(context.Background()); err != nil {
+		t.Fatalf("Authenticate() returned unexpected error: %v", err)
+	}
+
+	if len(sink.calls) != 1 {
+		t.Fatalf("Audit called %d times, want 1", len(sink.calls))
+	}
+	events := sink.calls[0]
+	if len(events) != 2 {
+		t.Fatalf("Audit received %d events, want 2", len(events))
+	}
+	if events[0].AuthenticatorType != "fail" || events[0].Success || events[0].Error == "" {
+		t.Fatalf("events[0] = %+v, want a failed attempt for %q", events[0], "fail")
+	}
+	if events[1].AuthenticatorType != "succeed" || !events[1].Success || len(events[1].Identities) != 1 {
+		t.Fatalf("events[1] = %+v, want a successful attempt for %q", events[1], "succeed")
+	}
+}
+
+func TestAuthenticatorChain_WithAuditSinkReceivesAllFailedAttempts(t *testing.T) {
+	fail1 := &fakeAuthenticator{authType: "first", err: fmt.Errorf("nope")}
+	fail2 := &fakeAuthenticator{authType: "second", err: fmt.Errorf("also nope")}
+
+	sink := &fakeAuditSink{}
+	c := NewAuthenticatorChain(fail1, fail2).WithAuditSink(sink)
+
+	if _, err := c.Authenticate(context.Background()); err == nil {
+		t.Fatalf("Authenticate() = nil error; want failure")
+	}
+
+	if len(sink.calls) != 1 || len(sink.calls[0]) != 2 {
+		t.Fatalf("Audit calls = %v, want a single call with 2 events", sink.calls)
+	}
+}
@@ -0,0 +1,225 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"golang.org/x/net/context"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	JWTSVIDAuthenticatorType = "JWTSVIDAuthenticator"
+
+	spiffeScheme                   = "spiffe://"
+	defaultTrustBundleRefreshCycle = 1 * time.Hour
+)
+
+// TrustDomainSource configures where JWTSVIDAuthenticator gets the signing
+// keys for a SPIFFE trust domain: a local JWKS trust bundle, or spiffe-oidc
+// discovery.
+type TrustDomainSource struct {
+	// TrustDomain these keys sign JWT-SVIDs for, e.g. "example.org".
+	TrustDomain string
+	// JWKSURI, if set, is fetched directly as a static trust bundle.
+	JWKSURI string
+	// OIDCDiscoveryURL is used to discover the trust domain's spiffe-oidc
+	// provider if JWKSURI is not set.
+	OIDCDiscoveryURL string
+	// RefreshInterval controls how often keys are re-fetched. Defaults to
+	// defaultTrustBundleRefreshCycle.
+	RefreshInterval time.Duration
+}
+
+// JWTSVIDAuthenticator validates SPIFFE JWT-SVIDs: `aud` must contain the
+// server's own SPIFFE ID, and `sub` must be a spiffe:// URI whose trust
+// domain is registered via a TrustDomainSource.
+type JWTSVIDAuthenticator struct {
+	serverID string
+
+	mu       sync.RWMutex
+	verifier map[string]*oidc.IDTokenVerifier
+
+	stopCh chan struct{}
+}
+
+// NewJWTSVIDAuthenticator creates a JWTSVIDAuthenticator that accepts
+// JWT-SVIDs whose `aud` claim contains serverID and that are signed by one
+// of the trust domains in sources. An empty serverID disables the audience
+// check, so tokens with any `aud` are accepted.
+func NewJWTSVIDAuthenticator(serverID string, sources []TrustDomainSource) (*JWTSVIDAuthenticator, error) {
+	a := &JWTSVIDAuthenticator{
+		serverID: serverID,
+		verifier: make(map[string]*oidc.IDTokenVerifier),
+		stopCh:   make(chan struct{}),
+	}
+
+	for _, src := range sources {
+		verifier, err := a.buildVerifier(src)
+		if err != nil {
+			// Stop the refreshLoop goroutines already started for earlier
+			// sources; otherwise they'd leak for the life of the process
+			// since the caller never receives a to call Close on.
+			close(a.stopCh)
+			return nil, err
+		}
+		a.verifier[src.TrustDomain] = verifier
+
+		refresh := src.RefreshInterval
+		if refresh <= 0 {
+			refresh = defaultTrustBundleRefreshCycle
+		}
+		go a.refreshLoop(src, refresh)
+	}
+
+	return a, nil
+}
+
+func (a *JWTSVIDAuthenticator) buildVerifier(src TrustDomainSource) (*oidc.IDTokenVerifier, error) {
+	config := &oidc.Config{ClientID: a.serverID, SkipClientIDCheck: a.serverID == ""}
+
+	switch {
+	case src.JWKSURI != "":
+		keySet := oidc.NewRemoteKeySet(context.Background(), src.JWKSURI)
+		return oidc.NewVerifier(spiffeScheme+src.TrustDomain, keySet, config), nil
+	case src.OIDCDiscoveryURL != "":
+		provider, err := oidc.NewProvider(context.Background(), src.OIDCDiscoveryURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover spiffe-oidc provider for trust domain %q: %v", src.TrustDomain, err)
+		}
+		return provider.Verifier(config), nil
+	default:
+		return nil, fmt.Errorf("trust domain %q has neither JWKSURI nor OIDCDiscoveryURL configured", src.TrustDomain)
+	}
+}
+
+// refreshLoop periodically rebuilds the verifier for src to pick up trust
+// bundle key rotation.
+func (a *JWTSVIDAuthenticator) refreshLoop(src TrustDomainSource, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopCh:
+			return
+		case <-ticker.C:
+			verifier, err := a.buildVerifier(src)
+			if err != nil {
+				log.Errorf("failed to refresh trust bundle for trust domain %q, keeping existing keys: %v", src.TrustDomain, err)
+				continue
+			}
+			a.mu.Lock()
+			a.verifier[src.TrustDomain] = verifier
+			a.mu.Unlock()
+		}
+	}
+}
+
+// Close stops all background trust bundle refresh goroutines.
+func (a *JWTSVIDAuthenticator) Close() {
+	close(a.stopCh)
+}
+
+func (a *JWTSVIDAuthenticator) AuthenticatorType() string {
+	return JWTSVIDAuthenticatorType
+}
+
+// Authenticate verifies the JWT-SVID bearer token in ctx and returns a
+// Caller whose Identities contains the SPIFFE ID from the `sub` claim.
+func (a *JWTSVIDAuthenticator) Authenticate(ctx context.Context) (*Caller, error) {
+	bearerToken, err := extractBearerToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("JWT-SVID extraction error: %v", err)
+	}
+
+	trustDomain, err := unverifiedSPIFFETrustDomain(bearerToken)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.RLock()
+	verifier, ok := a.verifier[trustDomain]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no trust bundle registered for trust domain %q", trustDomain)
+	}
+
+	idToken, err := verifier.Verify(ctx, bearerToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify JWT-SVID for trust domain %q: %v", trustDomain, err)
+	}
+
+	// Empty serverID means the verifier was built with SkipClientIDCheck;
+	// this must agree with that and skip the manual check too.
+	if a.serverID != "" && !containsAudience(idToken.Audience, a.serverID) {
+		return nil, fmt.Errorf("JWT-SVID audience %v does not contain server identity %q", idToken.Audience, a.serverID)
+	}
+
+	spiffeID := idToken.Subject
+	if !strings.HasPrefix(spiffeID, spiffeScheme) {
+		return nil, fmt.Errorf("JWT-SVID sub claim %q is not a spiffe:// URI", spiffeID)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to extract claims from JWT-SVID: %v", err)
+	}
+
+	return &Caller{
+		AuthSource:        AuthSourceJWTSVID,
+		Identities:        []string{spiffeID},
+		AuthenticatorType: JWTSVIDAuthenticatorType,
+		Claims:            claims,
+	}, nil
+}
+
+func containsAudience(audiences []string, want string) bool {
+	for _, aud := range audiences {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+// unverifiedSPIFFETrustDomain extracts the trust domain from the `sub` claim
+// without verifying the token's signature, so it can be routed to the right
+// trust bundle before verification.
+func unverifiedSPIFFETrustDomain(rawIDToken string) (string, error) {
+	sub, err := unverifiedClaim(rawIDToken, "sub")
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(sub, spiffeScheme) {
+		return "", fmt.Errorf("sub claim %q is not a spiffe:// URI", sub)
+	}
+
+	rest := strings.TrimPrefix(sub, spiffeScheme)
+	trustDomain := rest
+	if idx := strings.Index(rest, "/"); idx != -1 {
+		trustDomain = rest[:idx]
+	}
+	if trustDomain == "" {
+		return "", fmt.Errorf("sub claim %q has no trust domain", sub)
+	}
+	return trustDomain, nil
+}
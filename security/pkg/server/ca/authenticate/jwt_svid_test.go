@@ -0,0 +1,157 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestContainsAudience(t *testing.T) {
+	tests := []struct {
+		name      string
+		audiences []string
+		want      string
+		match     bool
+	}{
+		{name: "present", audiences: []string{"a", "b"}, want: "b", match: true},
+		{name: "absent", audiences: []string{"a", "b"}, want: "c", match: false},
+		{name: "empty list", audiences: nil, want: "a", match: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := containsAudience(tt.audiences, tt.want); got != tt.match {
+				t.Fatalf("containsAudience(%v, %q) = %v, want %v", tt.audiences, tt.want, got, tt.match)
+			}
+		})
+	}
+}
+
+// TestJWTSVIDAuthenticator_EmptyServerIDSkipsAudienceCheck exercises the
+// real Authenticate path end to end with a signed JWT-SVID whose audience
+// does not contain the server identity. With serverID == "", buildVerifier
+// sets SkipClientIDCheck, so Authenticate's own audience check must agree
+// and accept the token rather than rejecting it because "" is never itself
+// a member of idToken.Audience.
+func TestJWTSVIDAuthenticator_EmptyServerIDSkipsAudienceCheck(t *testing.T) {
+	key := newTestRSAKey(t)
+	jwks := newTestJWKSServer(t, key)
+	defer jwks.Close()
+
+	const trustDomain = "example.org"
+	a, err := NewJWTSVIDAuthenticator("", []TrustDomainSource{
+		{TrustDomain: trustDomain, JWKSURI: jwks.URL},
+	})
+	if err != nil {
+		t.Fatalf("NewJWTSVIDAuthenticator returned error: %v", err)
+	}
+	defer a.Close()
+
+	token := signTestJWT(t, key, defaultTestClaims(
+		spiffeScheme+trustDomain,
+		spiffeScheme+trustDomain+"/ns/default/sa/foo",
+		"some-other-audience",
+	), nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMeta, bearerTokenPrefix+token))
+	caller, err := a.Authenticate(ctx)
+	if err != nil {
+		t.Fatalf("Authenticate with empty serverID should accept any audience, got error: %v", err)
+	}
+	if want := spiffeScheme + trustDomain + "/ns/default/sa/foo"; len(caller.Identities) != 1 || caller.Identities[0] != want {
+		t.Fatalf("Authenticate identities = %v, want [%q]", caller.Identities, want)
+	}
+}
+
+// TestJWTSVIDAuthenticator_AudienceMismatchRejected is the converse of the
+// above: a non-empty serverID must still reject a JWT-SVID whose audience
+// doesn't contain it.
+func TestJWTSVIDAuthenticator_AudienceMismatchRejected(t *testing.T) {
+	key := newTestRSAKey(t)
+	jwks := newTestJWKSServer(t, key)
+	defer jwks.Close()
+
+	const trustDomain = "example.org"
+	const serverID = "spiffe://example.org/ns/istio-system/sa/istiod"
+	a, err := NewJWTSVIDAuthenticator(serverID, []TrustDomainSource{
+		{TrustDomain: trustDomain, JWKSURI: jwks.URL},
+	})
+	if err != nil {
+		t.Fatalf("NewJWTSVIDAuthenticator returned error: %v", err)
+	}
+	defer a.Close()
+
+	token := signTestJWT(t, key, defaultTestClaims(
+		spiffeScheme+trustDomain,
+		spiffeScheme+trustDomain+"/ns/default/sa/foo",
+		"some-other-audience",
+	), nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMeta, bearerTokenPrefix+token))
+	if _, err := a.Authenticate(ctx); err == nil {
+		t.Fatalf("Authenticate with mismatched audience should have been rejected")
+	}
+}
+
+func TestUnverifiedSPIFFETrustDomain(t *testing.T) {
+	tests := []struct {
+		name    string
+		token   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "trust domain with path",
+			// {"sub":"spiffe://example.org/ns/default/sa/foo"}
+			token:   "h." + "eyJzdWIiOiJzcGlmZmU6Ly9leGFtcGxlLm9yZy9ucy9kZWZhdWx0L3NhL2ZvbyJ9" + ".s",
+			want:    "example.org",
+			wantErr: false,
+		},
+		{
+			name: "trust domain without path",
+			// {"sub":"spiffe://example.org"}
+			token:   "h." + "eyJzdWIiOiJzcGlmZmU6Ly9leGFtcGxlLm9yZyJ9" + ".s",
+			want:    "example.org",
+			wantErr: false,
+		},
+		{
+			name: "non-spiffe subject",
+			// {"sub":"user-1"}
+			token:   "h." + "eyJzdWIiOiJ1c2VyLTEifQ" + ".s",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unverifiedSPIFFETrustDomain(tt.token)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("unverifiedSPIFFETrustDomain(%q) = %q, nil; want error", tt.token, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unverifiedSPIFFETrustDomain(%q) returned unexpected error: %v", tt.token, err)
+			}
+			if got != tt.want {
+				t.Fatalf("unverifiedSPIFFETrustDomain(%q) = %q, want %q", tt.token, got, tt.want)
+			}
+		})
+	}
+}
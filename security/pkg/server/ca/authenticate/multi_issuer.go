@@ -0,0 +1,326 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	oidc "github.com/coreos/go-oidc"
+	"golang.org/x/net/context"
+
+	"istio.io/istio/pkg/log"
+)
+
+const (
+	MultiIssuerAuthenticatorType = "MultiIssuerAuthenticator"
+
+	// ClaimEmail, ClaimSub and ClaimPreferredUsername select which claim
+	// populates Caller.Identities; any other value is a dotted JSON path.
+	ClaimEmail             = "email"
+	ClaimSub               = "sub"
+	ClaimPreferredUsername = "preferred_username"
+
+	defaultJWKSRefreshInterval = 1 * time.Hour
+	minJWKSRetryBackoff        = 1 * time.Second
+	maxJWKSRetryBackoff        = 1 * time.Minute
+)
+
+// IssuerConfig describes a single OIDC issuer that MultiIssuerAuthenticator
+// is willing to accept tokens from.
+type IssuerConfig struct {
+	// Issuer is the `iss` value this configuration applies to, e.g.
+	// "https://accounts.google.com".
+	Issuer string `json:"issuer"`
+	// Audience is the expected `aud` value (the OAuth2 client ID).
+	Audience string `json:"audience"`
+	// ClaimName is one of ClaimEmail/ClaimSub/ClaimPreferredUsername, or a
+	// dotted JSON path (e.g. "federated_claims.user_id").
+	ClaimName string `json:"claimName"`
+	// JWKSRefreshInterval controls background key refresh. Defaults to
+	// defaultJWKSRefreshInterval.
+	JWKSRefreshInterval time.Duration `json:"jwksRefreshInterval"`
+}
+
+// issuerVerifier bundles the resolved oidc.Provider/Verifier for an issuer
+// with the config used to build it.
+type issuerVerifier struct {
+	cfg      IssuerConfig
+	verifier *oidc.IDTokenVerifier
+	provider *oidc.Provider
+
+	stopCh chan struct{}
+}
+
+// MultiIssuerAuthenticator extracts identity from a JWT issued by any one of
+// a set of registered OIDC issuers, dispatching by the unverified `iss`
+// claim. Issuers can be added or removed while the process is running.
+type MultiIssuerAuthenticator struct {
+	mu      sync.RWMutex
+	issuers map[string]*issuerVerifier
+}
+
+// NewMultiIssuerAuthenticator creates a MultiIssuerAuthenticator with no
+// registered issuers. Use AddIssuer to register issuers.
+func NewMultiIssuerAuthenticator() *MultiIssuerAuthenticator {
+	return &MultiIssuerAuthenticator{
+		issuers: make(map[string]*issuerVerifier),
+	}
+}
+
+func (a *MultiIssuerAuthenticator) AuthenticatorType() string {
+	return MultiIssuerAuthenticatorType
+}
+
+// AddIssuer performs OIDC discovery against cfg.Issuer and registers it so
+// that subsequent Authenticate calls can verify tokens issued by it. It is
+// safe to call AddIssuer again for an issuer that is already registered; the
+// existing verifier is replaced.
+func (a *MultiIssuerAuthenticator) AddIssuer(cfg IssuerConfig) error {
+	if cfg.Issuer == "" {
+		return fmt.Errorf("issuer must not be empty")
+	}
+	if cfg.JWKSRefreshInterval <= 0 {
+		cfg.JWKSRefreshInterval = defaultJWKSRefreshInterval
+	}
+	if cfg.ClaimName == "" {
+		cfg.ClaimName = ClaimEmail
+	}
+
+	provider, err := oidc.NewProvider(context.Background(), cfg.Issuer)
+	if err != nil {
+		return fmt.Errorf("failed to discover OIDC issuer %q: %v", cfg.Issuer, err)
+	}
+	verifier := provider.Verifier(&oidc.Config{ClientID: cfg.Audience})
+
+	iv := &issuerVerifier{
+		cfg:      cfg,
+		verifier: verifier,
+		provider: provider,
+		stopCh:   make(chan struct{}),
+	}
+
+	a.mu.Lock()
+	if old, exists := a.issuers[cfg.Issuer]; exists {
+		close(old.stopCh)
+	}
+	a.issuers[cfg.Issuer] = iv
+	a.mu.Unlock()
+
+	go a.refreshJWKSLoop(iv)
+
+	return nil
+}
+
+// RemoveIssuer stops background JWKS refresh for iss and forgets it. Tokens
+// from iss are rejected once this returns.
+func (a *MultiIssuerAuthenticator) RemoveIssuer(iss string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if iv, exists := a.issuers[iss]; exists {
+		close(iv.stopCh)
+		delete(a.issuers, iss)
+	}
+}
+
+// refreshJWKSLoop periodically re-runs OIDC discovery for iv to pick up key
+// rotation, retrying failures with jittered backoff. Authenticate reads an
+// *issuerVerifier without holding a.mu for the Verify call, so a successful
+// refresh must swap in a brand-new *issuerVerifier via swapIfCurrent rather
+// than mutating iv's fields in place.
+func (a *MultiIssuerAuthenticator) refreshJWKSLoop(iv *issuerVerifier) {
+	ticker := time.NewTicker(iv.cfg.JWKSRefreshInterval)
+	defer ticker.Stop()
+
+	backoff := minJWKSRetryBackoff
+	for {
+		select {
+		case <-iv.stopCh:
+			return
+		case <-ticker.C:
+			provider, err := oidc.NewProvider(context.Background(), iv.cfg.Issuer)
+			for err != nil {
+				log.Errorf("failed to refresh JWKS for issuer %q, will retry: %v", iv.cfg.Issuer, err)
+				jittered := backoff + time.Duration(rand.Int63n(int64(backoff)))
+				select {
+				case <-time.After(jittered):
+				case <-iv.stopCh:
+					return
+				}
+				backoff *= 2
+				if backoff > maxJWKSRetryBackoff {
+					backoff = maxJWKSRetryBackoff
+				}
+				provider, err = oidc.NewProvider(context.Background(), iv.cfg.Issuer)
+			}
+
+			backoff = minJWKSRetryBackoff
+			verifier := provider.Verifier(&oidc.Config{ClientID: iv.cfg.Audience})
+
+			newIv := &issuerVerifier{
+				cfg:      iv.cfg,
+				verifier: verifier,
+				provider: provider,
+				stopCh:   iv.stopCh,
+			}
+
+			a.swapIfCurrent(iv, newIv)
+			iv = newIv
+		}
+	}
+}
+
+// swapIfCurrent replaces a.issuers[old.cfg.Issuer] with newIv, but only if
+// old is still the registered entry; RemoveIssuer/AddIssuer may have
+// replaced or removed it while a refresh was in flight, in which case the
+// stale refresh result is dropped.
+func (a *MultiIssuerAuthenticator) swapIfCurrent(old, newIv *issuerVerifier) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.issuers[old.cfg.Issuer] == old {
+		a.issuers[old.cfg.Issuer] = newIv
+	}
+}
+
+// Authenticate authenticates a caller using the JWT in the context, routing
+// it to the verifier registered for its (unverified) `iss` claim.
+func (a *MultiIssuerAuthenticator) Authenticate(ctx context.Context) (*Caller, error) {
+	bearerToken, err := extractBearerToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ID token extraction error: %v", err)
+	}
+
+	iss, err := unverifiedIssuer(bearerToken)
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.RLock()
+	iv, ok := a.issuers[iss]
+	a.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no registered issuer for %q", iss)
+	}
+
+	idToken, err := iv.verifier.Verify(ctx, bearerToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify the ID token from issuer %q: %v", iss, err)
+	}
+
+	identity, err := extractClaim(idToken, iv.cfg.ClaimName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract claim %q from ID token issued by %q: %v", iv.cfg.ClaimName, iss, err)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to extract claims from ID token issued by %q: %v", iss, err)
+	}
+
+	return &Caller{
+		AuthSource:        AuthSourceIDToken,
+		Identities:        []string{identity},
+		AuthenticatorType: MultiIssuerAuthenticatorType,
+		Claims:            claims,
+	}, nil
+}
+
+// unverifiedIssuer extracts the `iss` claim from a JWT without verifying its
+// signature, so that it can be dispatched to the right verifier. The claim
+// is still verified normally (including issuer match) once routed.
+func unverifiedIssuer(rawIDToken string) (string, error) {
+	return unverifiedClaim(rawIDToken, "iss")
+}
+
+// unverifiedClaim extracts a single top-level string claim from a JWT
+// without verifying its signature. Callers must still verify the token
+// normally once it has been routed based on this claim.
+func unverifiedClaim(rawIDToken, claimName string) (string, error) {
+	parts := strings.Split(rawIDToken, ".")
+	if len(parts) < 2 {
+		return "", fmt.Errorf("malformed ID token")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("failed to decode ID token payload: %v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("failed to unmarshal ID token claims: %v", err)
+	}
+
+	value, ok := claims[claimName]
+	if !ok {
+		return "", fmt.Errorf("ID token has no %q claim", claimName)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("ID token %q claim is not a string", claimName)
+	}
+	return s, nil
+}
+
+// extractClaim resolves claimName (a ClaimEmail/ClaimSub/
+// ClaimPreferredUsername constant, or a dotted path) against the token.
+func extractClaim(idToken *oidc.IDToken, claimName string) (string, error) {
+	var raw map[string]interface{}
+	if err := idToken.Claims(&raw); err != nil {
+		return "", err
+	}
+
+	switch claimName {
+	case ClaimSub:
+		return idToken.Subject, nil
+	default:
+		value, err := lookupJSONPath(raw, strings.Split(claimName, "."))
+		if err != nil {
+			return "", err
+		}
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("claim %q is not a string", claimName)
+		}
+		return s, nil
+	}
+}
+
+func lookupJSONPath(obj map[string]interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("empty claim path")
+	}
+
+	value, ok := obj[path[0]]
+	if !ok {
+		return nil, fmt.Errorf("claim %q not present", path[0])
+	}
+	if len(path) == 1 {
+		return value, nil
+	}
+
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("claim %q is not an object", path[0])
+	}
+	return lookupJSONPath(nested, path[1:])
+}
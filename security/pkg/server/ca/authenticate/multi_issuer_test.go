@@ -0,0 +1,176 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestMultiIssuerAuthenticator_SwapIfCurrentIsRaceFree exercises the exact
+// pattern that used to race: one goroutine repeatedly swapping in a new
+// *issuerVerifier the way refreshJWKSLoop does, while other goroutines
+// repeatedly read the map the way Authenticate does and dereference fields
+// on whatever *issuerVerifier they observe. Before the fix, refreshJWKSLoop
+// mutated the fields of the existing *issuerVerifier in place, which a
+// concurrent unlocked reader in Authenticate could observe mid-write. Run
+// with `go test -race` to confirm no race is reported.
+func TestMultiIssuerAuthenticator_SwapIfCurrentIsRaceFree(t *testing.T) {
+	a := NewMultiIssuerAuthenticator()
+
+	const issuer = "https://issuer.example.com"
+	first := &issuerVerifier{
+		cfg:    IssuerConfig{Issuer: issuer, ClaimName: ClaimEmail},
+		stopCh: make(chan struct{}),
+	}
+
+	a.mu.Lock()
+	a.issuers[issuer] = first
+	a.mu.Unlock()
+
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+
+	// Readers: mimic Authenticate's lookup pattern and touch fields on the
+	// *issuerVerifier without holding a.mu, as the request path does.
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				a.mu.RLock()
+				iv, ok := a.issuers[issuer]
+				a.mu.RUnlock()
+				if ok {
+					_ = iv.cfg.Issuer
+				}
+			}
+		}()
+	}
+
+	// Writer: repeatedly build a brand-new *issuerVerifier and swap it in,
+	// exactly as refreshJWKSLoop does on a successful refresh.
+	prev := first
+	for i := 0; i < 1000; i++ {
+		next := &issuerVerifier{
+			cfg:    prev.cfg,
+			stopCh: prev.stopCh,
+		}
+		a.swapIfCurrent(prev, next)
+		prev = next
+	}
+
+	close(stop)
+	wg.Wait()
+}
+
+// TestMultiIssuerAuthenticator_SwapIfCurrentDropsStaleRefresh verifies that
+// a refresh racing with RemoveIssuer does not resurrect the entry that was
+// removed.
+func TestMultiIssuerAuthenticator_SwapIfCurrentDropsStaleRefresh(t *testing.T) {
+	a := NewMultiIssuerAuthenticator()
+	const issuer = "https://issuer.example.com"
+
+	old := &issuerVerifier{cfg: IssuerConfig{Issuer: issuer}, stopCh: make(chan struct{})}
+	a.mu.Lock()
+	a.issuers[issuer] = old
+	a.mu.Unlock()
+
+	// Simulate RemoveIssuer happening before the in-flight refresh returns.
+	a.mu.Lock()
+	delete(a.issuers, issuer)
+	a.mu.Unlock()
+
+	stale := &issuerVerifier{cfg: old.cfg, stopCh: old.stopCh}
+	a.swapIfCurrent(old, stale)
+
+	a.mu.RLock()
+	_, ok := a.issuers[issuer]
+	a.mu.RUnlock()
+	if ok {
+		t.Fatalf("swapIfCurrent resurrected a removed issuer")
+	}
+}
+
+func TestLookupJSONPath(t *testing.T) {
+	obj := map[string]interface{}{
+		"sub": "user-1",
+		"federated_claims": map[string]interface{}{
+			"user_id":   "12345",
+			"connector": "ldap",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		path    []string
+		want    interface{}
+		wantErr bool
+	}{
+		{name: "top level", path: []string{"sub"}, want: "user-1"},
+		{name: "nested", path: []string{"federated_claims", "user_id"}, want: "12345"},
+		{name: "missing top level", path: []string{"nope"}, wantErr: true},
+		{name: "missing nested", path: []string{"federated_claims", "nope"}, wantErr: true},
+		{name: "descends into non-object", path: []string{"sub", "nope"}, wantErr: true},
+		{name: "empty path", path: []string{}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := lookupJSONPath(obj, tt.path)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("lookupJSONPath(%v) = %v, nil; want error", tt.path, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("lookupJSONPath(%v) returned unexpected error: %v", tt.path, err)
+			}
+			if got != tt.want {
+				t.Fatalf("lookupJSONPath(%v) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnverifiedClaim(t *testing.T) {
+	// {"iss":"https://issuer.example.com","sub":"user-1"} base64url encoded,
+	// with a dummy header/signature since unverifiedClaim never checks them.
+	const token = "eyJhbGciOiJub25lIn0." +
+		"eyJpc3MiOiJodHRwczovL2lzc3Vlci5leGFtcGxlLmNvbSIsInN1YiI6InVzZXItMSJ9." +
+		"sig"
+
+	iss, err := unverifiedIssuer(token)
+	if err != nil {
+		t.Fatalf("unverifiedIssuer returned error: %v", err)
+	}
+	if want := "https://issuer.example.com"; iss != want {
+		t.Fatalf("unverifiedIssuer = %q, want %q", iss, want)
+	}
+
+	if _, err := unverifiedClaim(token, "aud"); err == nil {
+		t.Fatalf("unverifiedClaim(aud) on token without an aud claim: want error, got nil")
+	}
+
+	if _, err := unverifiedClaim("not-a-jwt", "iss"); err == nil {
+		t.Fatalf("unverifiedClaim on malformed token: want error, got nil")
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"fmt"
+
+	oidc "github.com/coreos/go-oidc"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+const OAuth2TokenSourceAuthenticatorType = "OAuth2TokenSourceAuthenticator"
+
+// OAuth2TokenSourceAuthenticator authenticates callers using JWTs minted by
+// an oauth2.TokenSource (e.g. a service-account JWT source). It also doubles
+// as an outbound gRPC credential so Istio components can authenticate to
+// external CAs without provider-specific glue code.
+type OAuth2TokenSourceAuthenticator struct {
+	ts       oauth2.TokenSource
+	aud      string
+	verifier *oidc.IDTokenVerifier
+}
+
+// NewOAuth2TokenSourceAuthenticator creates an authenticator backed by ts.
+// Inbound tokens are verified with the JWKS of the issuer discovered from
+// issuer; aud is the expected audience of inbound tokens. Outbound tokens
+// are taken from ts as they are; aud is not applied to them.
+func NewOAuth2TokenSourceAuthenticator(ts oauth2.TokenSource, issuer, aud string) (*OAuth2TokenSourceAuthenticator, error) {
+	provider, err := oidc.NewProvider(context.Background(), issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC issuer %q: %v", issuer, err)
+	}
+
+	return &OAuth2TokenSourceAuthenticator{
+		ts:       ts,
+		aud:      aud,
+		verifier: provider.Verifier(&oidc.Config{ClientID: aud}),
+	}, nil
+}
+
+func (a *OAuth2TokenSourceAuthenticator) AuthenticatorType() string {
+	return OAuth2TokenSourceAuthenticatorType
+}
+
+// Authenticate verifies a bearer token minted by the same token source
+// family as a.ts (e.g. a service account JWT), following the same flow as
+// IDTokenAuthenticator.Authenticate.
+func (a *OAuth2TokenSourceAuthenticator) Authenticate(ctx context.Context) (*Caller, error) {
+	bearerToken, err := extractBearerToken(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ID token extraction error: %v", err)
+	}
+
+	idToken, err := a.verifier.Verify(ctx, bearerToken)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify the ID token (error %v)", err)
+	}
+
+	var sa struct {
+		Email string `json:"email"`
+	}
+	if err := idToken.Claims(&sa); err != nil {
+		return nil, fmt.Errorf("failed to extract email field from ID token: %v", err)
+	}
+
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to extract claims from ID token: %v", err)
+	}
+
+	return &Caller{
+		AuthSource:        AuthSourceIDToken,
+		Identities:        []string{sa.Email},
+		AuthenticatorType: OAuth2TokenSourceAuthenticatorType,
+		Claims:            claims,
+	}, nil
+}
+
+// PerRPCCredentials returns a credentials.PerRPCCredentials that attaches a
+// token minted by a.ts as a Bearer authorization header, suitable for
+// citadel and node-agent gRPC clients calling out to an external CA.
+func (a *OAuth2TokenSourceAuthenticator) PerRPCCredentials() *oauth2TokenSourcePerRPCCredentials {
+	return &oauth2TokenSourcePerRPCCredentials{ts: a.ts}
+}
+
+// oauth2TokenSourcePerRPCCredentials implements
+// google.golang.org/grpc/credentials.PerRPCCredentials on top of an
+// oauth2.TokenSource.
+type oauth2TokenSourcePerRPCCredentials struct {
+	ts oauth2.TokenSource
+}
+
+func (c *oauth2TokenSourcePerRPCCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token, err := c.ts.Token()
+	if err != nil {
+		return nil, fmt.Errorf("failed to obtain token from token source: %v", err)
+	}
+
+	// Prefer the OIDC id_token when the source provides one (e.g. a
+	// service-account JWT source): it's a verifiable JWT, whereas
+	// AccessToken from an opaque source like google.DefaultTokenSource is
+	// not and cannot be verified by Authenticate on the receiving side.
+	bearer := token.AccessToken
+	if idToken, ok := token.Extra("id_token").(string); ok && idToken != "" {
+		bearer = idToken
+	}
+
+	return map[string]string{
+		authorizationMeta: bearerTokenPrefix + bearer,
+	}, nil
+}
+
+func (c *oauth2TokenSourcePerRPCCredentials) RequireTransportSecurity() bool {
+	return true
+}
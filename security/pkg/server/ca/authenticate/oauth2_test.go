@@ -0,0 +1,115 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestOAuth2TokenSourceAuthenticator_Authenticate(t *testing.T) {
+	key := newTestRSAKey(t)
+	jwks := newTestJWKSServer(t, key)
+	defer jwks.Close()
+	discovery := newTestOIDCDiscoveryServer(t, jwks.URL)
+	defer discovery.Close()
+
+	const aud = "https://ca.example.com"
+	a, err := NewOAuth2TokenSourceAuthenticator(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "unused"}), discovery.URL, aud)
+	if err != nil {
+		t.Fatalf("NewOAuth2TokenSourceAuthenticator returned error: %v", err)
+	}
+
+	claims := defaultTestClaims(discovery.URL, "ignored-subject", aud)
+	token := signTestJWT(t, key, claims, map[string]string{"email": "sa@example.iam.gserviceaccount.com"})
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMeta, bearerTokenPrefix+token))
+	caller, err := a.Authenticate(ctx)
+	if err != nil {
+		t.Fatalf("Authenticate returned error: %v", err)
+	}
+	if len(caller.Identities) != 1 || caller.Identities[0] != "sa@example.iam.gserviceaccount.com" {
+		t.Fatalf("Authenticate identities = %v, want [sa@example.iam.gserviceaccount.com]", caller.Identities)
+	}
+	if caller.Claims["email"] != "sa@example.iam.gserviceaccount.com" {
+		t.Fatalf("Authenticate claims[email] = %v, want sa@example.iam.gserviceaccount.com", caller.Claims["email"])
+	}
+}
+
+func TestOAuth2TokenSourceAuthenticator_AuthenticateRejectsWrongAudience(t *testing.T) {
+	key := newTestRSAKey(t)
+	jwks := newTestJWKSServer(t, key)
+	defer jwks.Close()
+	discovery := newTestOIDCDiscoveryServer(t, jwks.URL)
+	defer discovery.Close()
+
+	a, err := NewOAuth2TokenSourceAuthenticator(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "unused"}), discovery.URL, "https://ca.example.com")
+	if err != nil {
+		t.Fatalf("NewOAuth2TokenSourceAuthenticator returned error: %v", err)
+	}
+
+	claims := defaultTestClaims(discovery.URL, "ignored-subject", "https://someone-else.example.com")
+	token := signTestJWT(t, key, claims, nil)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(authorizationMeta, bearerTokenPrefix+token))
+	if _, err := a.Authenticate(ctx); err == nil {
+		t.Fatalf("Authenticate with wrong audience should have been rejected")
+	}
+}
+
+// fakeTokenSource lets tests control exactly what oauth2.Token.Extra
+// returns, to exercise GetRequestMetadata's id_token preference.
+type fakeTokenSource struct {
+	token *oauth2.Token
+}
+
+func (f fakeTokenSource) Token() (*oauth2.Token, error) {
+	return f.token, nil
+}
+
+func TestOauth2TokenSourcePerRPCCredentials_PrefersIDToken(t *testing.T) {
+	base := &oauth2.Token{AccessToken: "opaque-access-token"}
+	withIDToken := base.WithExtra(map[string]interface{}{"id_token": "signed.jwt.idtoken"})
+
+	creds := &oauth2TokenSourcePerRPCCredentials{ts: fakeTokenSource{token: withIDToken}}
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("GetRequestMetadata returned error: %v", err)
+	}
+	if want := bearerTokenPrefix + "signed.jwt.idtoken"; md[authorizationMeta] != want {
+		t.Fatalf("GetRequestMetadata()[%q] = %q, want %q", authorizationMeta, md[authorizationMeta], want)
+	}
+}
+
+func TestOauth2TokenSourcePerRPCCredentials_FallsBackToAccessToken(t *testing.T) {
+	creds := &oauth2TokenSourcePerRPCCredentials{ts: fakeTokenSource{token: &oauth2.Token{AccessToken: "opaque-access-token"}}}
+	md, err := creds.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatalf("GetRequestMetadata returned error: %v", err)
+	}
+	if want := bearerTokenPrefix + "opaque-access-token"; md[authorizationMeta] != want {
+		t.Fatalf("GetRequestMetadata()[%q] = %q, want %q", authorizationMeta, md[authorizationMeta], want)
+	}
+}
+
+func TestOauth2TokenSourcePerRPCCredentials_RequireTransportSecurity(t *testing.T) {
+	creds := &oauth2TokenSourcePerRPCCredentials{}
+	if !creds.RequireTransportSecurity() {
+		t.Fatalf("RequireTransportSecurity() = false, want true")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const testKeyID = "test-key"
+
+// newTestJWKSServer starts an httptest.Server serving a JWKS containing
+// key's public half under testKeyID, for use with oidc.NewRemoteKeySet or an
+// OIDC discovery document's jwks_uri.
+func newTestJWKSServer(t *testing.T, key *rsa.PrivateKey) *httptest.Server {
+	t.Helper()
+
+	jwks := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: &key.PublicKey, KeyID: testKeyID, Algorithm: string(jose.RS256), Use: "sig"},
+		},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(jwks); err != nil {
+			t.Fatalf("failed to encode JWKS: %v", err)
+		}
+	}))
+}
+
+// newTestOIDCDiscoveryServer starts an httptest.Server serving an OIDC
+// discovery document whose issuer is the server's own URL and whose
+// jwks_uri points at jwksURL.
+func newTestOIDCDiscoveryServer(t *testing.T, jwksURL string) *httptest.Server {
+	t.Helper()
+
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		doc := map[string]interface{}{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               jwksURL,
+		}
+		if err := json.NewEncoder(w).Encode(doc); err != nil {
+			t.Fatalf("failed to encode discovery document: %v", err)
+		}
+	}))
+	return srv
+}
+
+// newTestRSAKey generates an RSA key pair for signing test JWTs.
+func newTestRSAKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	return key
+}
+
+// signTestJWT signs claims with key under testKeyID, producing a compact
+// JWT suitable for verification against newTestJWKSServer's JWKS.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, claims jwt.Claims, extra interface{}) string {
+	t.Helper()
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.RS256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: testKeyID, Algorithm: string(jose.RS256), Use: "sig"},
+	}, (&jose.SignerOptions{}).WithType("JWT"))
+	if err != nil {
+		t.Fatalf("failed to create signer: %v", err)
+	}
+
+	builder := jwt.Signed(signer).Claims(claims)
+	if extra != nil {
+		builder = builder.Claims(extra)
+	}
+	raw, err := builder.CompactSerialize()
+	if err != nil {
+		t.Fatalf("failed to sign test JWT: %v", err)
+	}
+	return raw
+}
+
+func defaultTestClaims(issuer, subject, audience string) jwt.Claims {
+	now := time.Now()
+	return jwt.Claims{
+		Issuer:   issuer,
+		Subject:  subject,
+		Audience: jwt.Audience{audience},
+		Expiry:   jwt.NewNumericDate(now.Add(time.Hour)),
+		IssuedAt: jwt.NewNumericDate(now),
+	}
+}
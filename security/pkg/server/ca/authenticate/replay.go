@@ -0,0 +1,107 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultReplayCacheCapacity bounds jtiReplayCache regardless of token
+// expiry, so a burst of long-lived tokens can't grow it unbounded.
+const defaultReplayCacheCapacity = 8192
+
+// NonceSource validates an OIDC `nonce` claim against the value a caller was
+// handed for a particular authentication exchange.
+type NonceSource interface {
+	// VerifyNonce returns an error if nonce is unknown, already consumed, or
+	// expired.
+	VerifyNonce(nonce string) error
+}
+
+// jtiReplayCache is a small in-memory LRU of previously seen `jti` claims.
+// Entries are evicted on expiry (TTL bounded by the token's own `exp`) or
+// once the cache exceeds its capacity, whichever comes first.
+type jtiReplayCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	entries  map[string]*list.Element
+}
+
+type jtiEntry struct {
+	jti       string
+	expiresAt time.Time
+}
+
+func newJTIReplayCache(capacity int) *jtiReplayCache {
+	if capacity <= 0 {
+		capacity = defaultReplayCacheCapacity
+	}
+	return &jtiReplayCache{
+		capacity: capacity,
+		ll:       list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// checkAndRecord returns an error if jti has already been seen and has not
+// yet expired; otherwise it records jti as seen until expiresAt and returns
+// nil.
+func (c *jtiReplayCache) checkAndRecord(jti string, expiresAt time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.evictExpiredLocked()
+
+	if elem, ok := c.entries[jti]; ok {
+		entry := elem.Value.(*jtiEntry)
+		if time.Now().Before(entry.expiresAt) {
+			return fmt.Errorf("token with jti %q has already been used", jti)
+		}
+		c.ll.Remove(elem)
+		delete(c.entries, jti)
+	}
+
+	elem := c.ll.PushFront(&jtiEntry{jti: jti, expiresAt: expiresAt})
+	c.entries[jti] = elem
+
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		if oldest == nil {
+			break
+		}
+		c.ll.Remove(oldest)
+		delete(c.entries, oldest.Value.(*jtiEntry).jti)
+	}
+
+	return nil
+}
+
+// evictExpiredLocked drops every entry whose TTL has passed. Callers must
+// hold c.mu. Unlike capacity-based eviction, expiry is not correlated with
+// insertion order (tokens can have arbitrary lifetimes), so this scans all
+// entries rather than just the LRU tail.
+func (c *jtiReplayCache) evictExpiredLocked() {
+	now := time.Now()
+	for jti, elem := range c.entries {
+		if !now.Before(elem.Value.(*jtiEntry).expiresAt) {
+			c.ll.Remove(elem)
+			delete(c.entries, jti)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Istio Authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package authenticate
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJTIReplayCache_RejectsReplay(t *testing.T) {
+	c := newJTIReplayCache(0)
+
+	expiresAt := time.Now().Add(time.Hour)
+	if err := c.checkAndRecord("jti-1", expiresAt); err != nil {
+		t.Fatalf("first use of jti-1 should be accepted, got error: %v", err)
+	}
+	if err := c.checkAndRecord("jti-1", expiresAt); err == nil {
+		t.Fatalf("replayed jti-1 should be rejected, got nil error")
+	}
+}
+
+func TestJTIReplayCache_AllowsReuseAfterExpiry(t *testing.T) {
+	c := newJTIReplayCache(0)
+
+	past := time.Now().Add(-time.Minute)
+	if err := c.checkAndRecord("jti-1", past); err != nil {
+		t.Fatalf("first use of jti-1 should be accepted, got error: %v", err)
+	}
+	// jti-1's TTL has already elapsed, so it must be treated as fresh again
+	// rather than permanently blocked.
+	if err := c.checkAndRecord("jti-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("jti-1 after its TTL elapsed should be accepted, got error: %v", err)
+	}
+}
+
+func TestJTIReplayCache_DistinctJTIsDoNotCollide(t *testing.T) {
+	c := newJTIReplayCache(0)
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := c.checkAndRecord("jti-1", expiresAt); err != nil {
+		t.Fatalf("jti-1 should be accepted, got error: %v", err)
+	}
+	if err := c.checkAndRecord("jti-2", expiresAt); err != nil {
+		t.Fatalf("jti-2 should be accepted, got error: %v", err)
+	}
+}
+
+func TestJTIReplayCache_EvictsOverCapacity(t *testing.T) {
+	c := newJTIReplayCache(2)
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := c.checkAndRecord("jti-1", expiresAt); err != nil {
+		t.Fatalf("jti-1 should be accepted, got error: %v", err)
+	}
+	if err := c.checkAndRecord("jti-2", expiresAt); err != nil {
+		t.Fatalf("jti-2 should be accepted, got error: %v", err)
+	}
+	// Pushes the cache over its capacity of 2, evicting the least recently
+	// used entry (jti-1).
+	if err := c.checkAndRecord("jti-3", expiresAt); err != nil {
+		t.Fatalf("jti-3 should be accepted, got error: %v", err)
+	}
+
+	if c.ll.Len() > 2 {
+		t.Fatalf("cache grew past its capacity: len=%d", c.ll.Len())
+	}
+	// jti-1 was evicted for capacity, not because it expired, so it must be
+	// accepted again rather than rejected as a replay.
+	if err := c.checkAndRecord("jti-1", expiresAt); err != nil {
+		t.Fatalf("evicted jti-1 should be accepted again, got error: %v", err)
+	}
+}